@@ -15,30 +15,404 @@ command after building:
 			-e LOGSPOUT="ignore" \
 			<REGISTRY>/<CUSTOM_LOGSPOUT>:<VERSION> \
 				./logspout fluentd://<FLUENTD_IP>:<FLUENTD_PORT>
+
+The route address also accepts unix:///path/to.sock for a local fluent-bit
+sidecar, and tls://<FLUENTD_IP>:<FLUENTD_PORT> for encrypted forwarding.
+The underlying fluent-logger-golang client only exposes certificate
+verification as a toggle, so TLS configuration is limited to
+FLUENTD_TLS_INSECURE_SKIP_VERIFY; there is no way to supply a custom CA
+or client certificate, so setting FLUENTD_TLS_CA, FLUENTD_TLS_CERT or
+FLUENTD_TLS_KEY fails the route with an error instead of silently
+dialing with unverified or default TLS.
+
+Set FLUENTD_TAG to a text/template string (e.g. "docker.{{.Name}}.{{.ImageName}}")
+to control the fluentd tag per message instead of TAG_PREFIX/TAG_SUFFIX_LABEL.
+
+FLUENTD_EXTRA (JSON object or "k=v,k=v" list), FLUENTD_LABELS and FLUENTD_ENV
+(comma-separated names) merge extra static fields, container labels and
+container env vars into every record.
+
+Set FLUENTD_PARSE_JSON=true to forward a JSON-encoded message.Data as a
+structured record instead of under FLUENTD_MESSAGE_KEY (default "log").
+Set FLUENTD_PARTIAL_MESSAGE_BUFFER=true to reassemble Docker partial-line
+messages into a single event, flushed after FLUENTD_PARTIAL_MESSAGE_TIMEOUT
+milliseconds of inactivity.
+
+On SIGTERM/SIGINT the adapter drains logstream for up to
+FLUENTD_SHUTDOWN_TIMEOUT milliseconds, closes the fluentd connection and
+exits the process (registering the signal handler here otherwise leaves
+the rest of logspout running after the default terminate behavior is
+suppressed).
+Set FLUENTD_METRICS_ADDR (e.g. ":9090") to expose Prometheus metrics for
+messages posted, bytes sent, post errors, reconnects, empty-message skips
+and post latency on /metrics.
+
+The route address may list several comma-separated endpoints, e.g.
+fluentd://host1:24224,host2:24224. FLUENTD_LB_STRATEGY selects how they are
+used: "failover" (default) sends to a primary endpoint and promotes the
+next healthy one after repeated errors; "roundrobin" rotates across all of
+them, every FLUENTD_LB_ROUNDROBIN_EVERY messages. Each endpoint has its own
+exponential-backoff circuit breaker, so a downed aggregator is skipped until
+it recovers.
 *
 *
 */
 import (
+	"bytes"
+	"encoding/json"
 	"log"
 	"math"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/fluent/fluent-logger-golang/fluent"
 	"github.com/gliderlabs/logspout/router"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	defaultProtocol    = "tcp"
+	defaultHost        = "127.0.0.1"
+	defaultPort        = 24224
 	defaultBufferLimit = 1024 * 1024
 
 	defaultRetryWait  = 1000
 	defaultMaxRetries = math.MaxInt32
+
+	defaultMessageKey            = "log"
+	defaultPartialMessageTimeout = 1000 * time.Millisecond
+	defaultShutdownTimeout       = 5 * time.Second
+
+	lbStrategyFailover   = "failover"
+	lbStrategyRoundRobin = "roundrobin"
+
+	defaultLBRoundRobinEvery = 1
+	circuitBackoffInitial    = 1 * time.Second
+	circuitBackoffMax        = 30 * time.Second
+
+	// failoverThreshold is how many consecutive failures the primary
+	// endpoint must accrue before writerPool.report promotes a backup.
+	// This keeps a single dropped ack from flipping the primary.
+	failoverThreshold = 3
+)
+
+// emptyMessagePattern matches blank/whitespace-only message data. It is
+// compiled once at package init instead of per message to keep Stream's hot
+// path allocation-free.
+var emptyMessagePattern = regexp.MustCompile(`^[[:space:]]*$`)
+
+var (
+	messagesPosted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logspout_fluentd_messages_posted_total",
+		Help: "Number of messages successfully posted to fluentd.",
+	})
+	bytesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logspout_fluentd_bytes_sent_total",
+		Help: "Number of message bytes sent to fluentd.",
+	})
+	postErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logspout_fluentd_post_errors_total",
+		Help: "Number of errors returned by fluentd PostWithTime.",
+	})
+	reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logspout_fluentd_reconnects_total",
+		Help: "Number of times posting recovered after one or more failures.",
+	})
+	emptyMessagesSkipped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logspout_fluentd_empty_messages_skipped_total",
+		Help: "Number of empty messages skipped before forwarding.",
+	})
+	postLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "logspout_fluentd_post_latency_seconds",
+		Help:    "Latency of fluentd PostWithTime calls.",
+		Buckets: prometheus.DefBuckets,
+	})
 )
 
+// serveMetrics exposes the Prometheus registry on addr/metrics, when
+// FLUENTD_METRICS_ADDR is set.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Println("fluentd-adapter metrics server error: ", err)
+		}
+	}()
+}
+
+// endpoint pairs a fluentd writer with a simple circuit breaker: repeated
+// PostWithTime failures open the circuit for an exponentially increasing
+// backoff, until a send is attempted again and succeeds.
+type endpoint struct {
+	address string
+	writer  *fluent.Fluent
+
+	mu       sync.Mutex
+	failures int
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+func newEndpoint(address string, writer *fluent.Fluent) *endpoint {
+	return &endpoint{address: address, writer: writer}
+}
+
+// available reports whether the circuit breaker allows a send attempt now.
+func (e *endpoint) available() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.failures == 0 || !time.Now().Before(e.retryAt)
+}
+
+// failureCount reports the number of consecutive PostWithTime failures
+// recorded since the last success.
+func (e *endpoint) failureCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.failures
+}
+
+func (e *endpoint) recordSuccess() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+	e.backoff = 0
+}
+
+func (e *endpoint) recordFailure() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures++
+	if e.backoff == 0 {
+		e.backoff = circuitBackoffInitial
+	} else if e.backoff < circuitBackoffMax {
+		e.backoff *= 2
+		if e.backoff > circuitBackoffMax {
+			e.backoff = circuitBackoffMax
+		}
+	}
+	e.retryAt = time.Now().Add(e.backoff)
+}
+
+// writerPool fans a FLUENTD_LB_STRATEGY out over one or more fluentd
+// endpoints, so a single downed aggregator doesn't stop delivery.
+type writerPool struct {
+	endpoints []*endpoint
+	strategy  string
+	every     int
+
+	mu      sync.Mutex
+	primary int
+	rrIndex int
+	rrSent  int
+}
+
+func newWriterPool(endpoints []*endpoint, strategy string, every int) *writerPool {
+	return &writerPool{endpoints: endpoints, strategy: strategy, every: every}
+}
+
+// pick returns the endpoint a message should be sent to next.
+func (p *writerPool) pick() *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.strategy == lbStrategyRoundRobin {
+		return p.pickRoundRobinLocked()
+	}
+	return p.pickFailoverLocked()
+}
+
+func (p *writerPool) pickFailoverLocked() *endpoint {
+	for i := range p.endpoints {
+		idx := (p.primary + i) % len(p.endpoints)
+		if p.endpoints[idx].available() {
+			return p.endpoints[idx]
+		}
+	}
+	// Nothing is healthy; stick with the primary and let it keep failing.
+	return p.endpoints[p.primary]
+}
+
+func (p *writerPool) pickRoundRobinLocked() *endpoint {
+	var chosen *endpoint
+	for i := range p.endpoints {
+		idx := (p.rrIndex + i) % len(p.endpoints)
+		if p.endpoints[idx].available() {
+			chosen = p.endpoints[idx]
+			break
+		}
+	}
+	if chosen == nil {
+		chosen = p.endpoints[p.rrIndex%len(p.endpoints)]
+	}
+
+	p.rrSent++
+	if p.rrSent >= p.every {
+		p.rrSent = 0
+		p.rrIndex = (p.rrIndex + 1) % len(p.endpoints)
+	}
+	return chosen
+}
+
+// report records the outcome of sending through ep, promoting the next
+// healthy endpoint to primary once the current primary has accrued
+// failoverThreshold consecutive failures.
+func (p *writerPool) report(ep *endpoint, err error) {
+	if err == nil {
+		ep.recordSuccess()
+		return
+	}
+	ep.recordFailure()
+
+	if p.strategy != lbStrategyFailover || len(p.endpoints) < 2 {
+		return
+	}
+	if ep.failureCount() < failoverThreshold {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints[p.primary] != ep {
+		return
+	}
+	for i, candidate := range p.endpoints {
+		if candidate != ep && candidate.available() {
+			log.Printf("fluentd-adapter promoting %s after failures on %s", candidate.address, ep.address)
+			p.primary = i
+			return
+		}
+	}
+}
+
+func (p *writerPool) close() {
+	for _, ep := range p.endpoints {
+		if err := ep.writer.Close(); err != nil {
+			log.Println("fluentd-adapter writer close error: ", err)
+		}
+	}
+}
+
+// location holds the pieces of a route.Address once it is parsed as a URL,
+// so NewAdapter can support schemes beyond the original host:port form.
+type location struct {
+	protocol string
+	host     string
+	port     int
+	path     string
+}
+
+// parseAddress parses a route.Address such as "host:port", "tcp://host:port",
+// "tls://host:port" or "unix:///var/run/fluent/fluent.sock" into a location.
+// This mirrors how Docker's fluentd logging driver resolves its log-opt
+// fluentd-address.
+func parseAddress(address string) (*location, error) {
+	if !strings.Contains(address, "://") {
+		address = defaultProtocol + "://" + address
+	}
+
+	addr, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid fluentd-address %s", address)
+	}
+
+	switch addr.Scheme {
+	case "unix":
+		if strings.TrimLeft(addr.Path, "/") == "" {
+			return nil, errors.Errorf("invalid fluentd-address %s: path is empty", address)
+		}
+		return &location{protocol: addr.Scheme, path: addr.Path}, nil
+	case "tcp", "tls":
+	default:
+		return nil, errors.Errorf("invalid fluentd-address protocol: %s", addr.Scheme)
+	}
+
+	host := addr.Hostname()
+	if host == "" {
+		host = defaultHost
+	}
+
+	port := defaultPort
+	if portStr := addr.Port(); portStr != "" {
+		port, err = strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fluentd-address %s", address)
+		}
+	}
+
+	return &location{protocol: addr.Scheme, host: host, port: port}, nil
+}
+
+// tlsInsecureSkipVerify reads FLUENTD_TLS_INSECURE_SKIP_VERIFY. The
+// fluent-logger-golang client only exposes certificate verification as a
+// toggle, so that's the only TLS knob available here; FLUENTD_TLS_CA,
+// FLUENTD_TLS_CERT and FLUENTD_TLS_KEY have no way to be honored and are
+// rejected outright rather than silently ignored, so a custom-CA or
+// mTLS setup doesn't end up dialing with unverified/default TLS.
+func tlsInsecureSkipVerify() (bool, error) {
+	for _, name := range []string{"FLUENTD_TLS_CA", "FLUENTD_TLS_CERT", "FLUENTD_TLS_KEY"} {
+		if getenv(name, "") != "" {
+			return false, errors.Errorf("%s is not supported: fluent-logger-golang only exposes TlsInsecureSkipVerify, with no way to supply a custom CA or client certificate", name)
+		}
+	}
+	return strconv.ParseBool(getenv("FLUENTD_TLS_INSECURE_SKIP_VERIFY", "false"))
+}
+
+// tagContext is the data exposed to a FLUENTD_TAG template, modeled after
+// Docker's own log tag context (see loggerutils.ParseLogTag).
+type tagContext struct {
+	ID         string
+	FullID     string
+	Name       string
+	ImageID    string
+	ImageName  string
+	DaemonName string
+	Source     string
+
+	labels map[string]string
+}
+
+// Label looks up a container label by name, for use as `{{.Label "key"}}`
+// inside a FLUENTD_TAG template.
+func (c tagContext) Label(name string) string {
+	return c.labels[name]
+}
+
+// newTagContext builds the template context for a single message.
+func newTagContext(message *router.Message, daemonName string) tagContext {
+	id := message.Container.ID
+	shortID := id
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+
+	return tagContext{
+		ID:         shortID,
+		FullID:     id,
+		Name:       strings.TrimPrefix(message.Container.Name, "/"),
+		ImageID:    message.Container.Image,
+		ImageName:  message.Container.Config.Image,
+		DaemonName: daemonName,
+		Source:     message.Source,
+		labels:     message.Container.Config.Labels,
+	}
+}
+
 func getenv(key, fallback string) string {
 	value := os.Getenv(key)
 	if len(value) == 0 {
@@ -47,24 +421,135 @@ func getenv(key, fallback string) string {
 	return value
 }
 
+// splitCSV splits a comma-separated list such as FLUENTD_LABELS into its
+// trimmed, non-empty entries.
+func splitCSV(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseExtraFields parses FLUENTD_EXTRA, accepting either a JSON object
+// (`{"env":"prod"}`) or a flat "k=v,k=v" list.
+func parseExtraFields(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		fields := map[string]string{}
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return nil, errors.Wrapf(err, "invalid FLUENTD_EXTRA JSON %s", value)
+		}
+		return fields, nil
+	}
+
+	fields := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, errors.Errorf("invalid FLUENTD_EXTRA entry %q, expected k=v", pair)
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return fields, nil
+}
+
+// lookupEnv finds KEY in a "KEY=VALUE" env list such as
+// message.Container.Config.Env.
+func lookupEnv(env []string, key string) (string, bool) {
+	prefix := key + "="
+	for _, entry := range env {
+		if strings.HasPrefix(entry, prefix) {
+			return entry[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
 // Adapter is an adapter for streaming JSON to a fluentd collector.
 type Adapter struct {
-	writer         *fluent.Fluent
+	pool           *writerPool
 	tagPrefix      string
 	tagSuffixLabel string
+	tagTemplate    *template.Template
+	daemonName     string
+	extraFields    map[string]string
+	labelKeys      []string
+	envKeys        []string
+
+	parseJSON  bool
+	messageKey string
+
+	partialMessageBuffer  bool
+	partialMessageTimeout time.Duration
+	partialsMu            sync.Mutex
+	partials              map[string]*partialMessage
+
+	shutdownTimeout     time.Duration
+	consecutiveFailures int32 // accessed atomically
 }
 
-// Stream handles a stream of messages from Logspout. Implements router.logAdapter.
-func (ad *Adapter) Stream(logstream chan *router.Message) {
-	for message := range logstream {
-		// Skip if message is empty
-		messageIsEmpty, err := regexp.MatchString("^[[:space:]]*$", message.Data)
-		if messageIsEmpty {
-			log.Println("Skipping empty message!")
-			continue
+// partialMessage accumulates the Data of a Docker partial-line message
+// (one not yet terminated by "\n") until it completes or times out.
+type partialMessage struct {
+	buf        bytes.Buffer
+	message    *router.Message
+	timer      *time.Timer
+	generation int
+}
+
+// injectExtra merges FLUENTD_EXTRA, FLUENTD_LABELS and FLUENTD_ENV into
+// record, so they are parsed once in NewAdapter and only looked up per
+// message here.
+func (ad *Adapter) injectExtra(record map[string]string, message *router.Message) {
+	for key, value := range ad.extraFields {
+		record[key] = value
+	}
+
+	for _, key := range ad.labelKeys {
+		if value, ok := message.Container.Config.Labels[key]; ok {
+			record[key] = value
 		}
+	}
 
-		// Set tag
+	for _, key := range ad.envKeys {
+		if value, ok := lookupEnv(message.Container.Config.Env, key); ok {
+			record[key] = value
+		}
+	}
+}
+
+// injectExtraInterface is injectExtra for the map[string]interface{} records
+// built from FLUENTD_PARSE_JSON messages.
+func (ad *Adapter) injectExtraInterface(record map[string]interface{}, message *router.Message) {
+	for key, value := range ad.extraFields {
+		record[key] = value
+	}
+
+	for _, key := range ad.labelKeys {
+		if value, ok := message.Container.Config.Labels[key]; ok {
+			record[key] = value
+		}
+	}
+
+	for _, key := range ad.envKeys {
+		if value, ok := lookupEnv(message.Container.Config.Env, key); ok {
+			record[key] = value
+		}
+	}
+}
+
+// buildTag derives the fluentd tag for a message, using tagTemplate when one
+// is configured (FLUENTD_TAG) and falling back to the tagPrefix/tagSuffixLabel
+// behavior otherwise.
+func (ad *Adapter) buildTag(message *router.Message) (string, error) {
+	if ad.tagTemplate == nil {
 		tag := ""
 		if len(ad.tagPrefix) > 0 {
 			tag = ad.tagPrefix
@@ -73,43 +558,245 @@ func (ad *Adapter) Stream(logstream chan *router.Message) {
 		if tagSuffix == "" {
 			tagSuffix = message.Container.Config.Hostname
 		}
-		tag = tag + "." + tagSuffix
+		return tag + "." + tagSuffix, nil
+	}
 
-		// Construct record
-		record := map[string]string{
-			"log":            message.Data,
-			"container_id":   message.Container.ID,
-			"container_name": message.Container.Name,
-			"source":         message.Source,
+	var buf bytes.Buffer
+	if err := ad.tagTemplate.Execute(&buf, newTagContext(message, ad.daemonName)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// buildRecord turns a message into the value posted to fluentd. When
+// FLUENTD_PARSE_JSON is set and message.Data parses as a JSON object, the
+// parsed fields are forwarded as-is (as MessagePack native types) instead of
+// being nested under messageKey.
+func (ad *Adapter) buildRecord(message *router.Message) interface{} {
+	if ad.parseJSON {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(message.Data), &parsed); err == nil && parsed != nil {
+			parsed["container_id"] = message.Container.ID
+			parsed["container_name"] = message.Container.Name
+			parsed["source"] = message.Source
+			ad.injectExtraInterface(parsed, message)
+			return parsed
 		}
-		log.Println(tag, message.Time, record)
+	}
 
-		// Send to fluentd
-		err = ad.writer.PostWithTime(tag, message.Time, record)
-		if err != nil {
-			log.Println("fluentd-adapter PostWithTime Error: ", err)
-			continue
+	record := map[string]string{
+		ad.messageKey:    message.Data,
+		"container_id":   message.Container.ID,
+		"container_name": message.Container.Name,
+		"source":         message.Source,
+	}
+	ad.injectExtra(record, message)
+	return record
+}
+
+// send builds the tag and record for message and posts it to fluentd,
+// recording Prometheus metrics for the attempt.
+func (ad *Adapter) send(message *router.Message) {
+	tag, err := ad.buildTag(message)
+	if err != nil {
+		log.Println("fluentd-adapter FLUENTD_TAG template error: ", err)
+		return
+	}
+
+	record := ad.buildRecord(message)
+	log.Println(tag, message.Time, record)
+
+	ep := ad.pool.pick()
+	start := time.Now()
+	err = ep.writer.PostWithTime(tag, message.Time, record)
+	postLatency.Observe(time.Since(start).Seconds())
+	ad.pool.report(ep, err)
+
+	if err != nil {
+		postErrors.Inc()
+		atomic.AddInt32(&ad.consecutiveFailures, 1)
+		log.Println("fluentd-adapter PostWithTime Error: ", err)
+		return
+	}
+
+	if atomic.SwapInt32(&ad.consecutiveFailures, 0) > 0 {
+		reconnects.Inc()
+	}
+	messagesPosted.Inc()
+	bytesSent.Add(float64(len(message.Data)))
+}
+
+// bufferPartial accumulates a Docker partial-line message (message.Data not
+// ending in "\n") keyed by container and source, returning nil while more
+// data is expected. It returns the completed message once a line terminates
+// or, via flushPartial, once partialMessageTimeout elapses with no more data.
+func (ad *Adapter) bufferPartial(message *router.Message) *router.Message {
+	key := message.Container.ID + "/" + message.Source
+	complete := strings.HasSuffix(message.Data, "\n")
+
+	ad.partialsMu.Lock()
+	defer ad.partialsMu.Unlock()
+
+	pending, buffering := ad.partials[key]
+	if !buffering {
+		if complete {
+			return message
 		}
+		pending = &partialMessage{}
+		ad.partials[key] = pending
+	}
+
+	pending.buf.WriteString(message.Data)
+	pending.message = message
+
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+
+	if complete {
+		delete(ad.partials, key)
+		merged := *pending.message
+		merged.Data = pending.buf.String()
+		return &merged
 	}
+
+	pending.generation++
+	generation := pending.generation
+	pending.timer = time.AfterFunc(ad.partialMessageTimeout, func() {
+		ad.flushPartial(key, generation)
+	})
+	return nil
 }
 
-// NewAdapter creates a Logspout fluentd adapter instance.
-func NewAdapter(route *router.Route) (router.LogAdapter, error) {
-	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
-	if !found {
-		return nil, errors.New("Unable to find adapter: " + route.Adapter)
+// flushPartial forwards a partial message that timed out without a
+// terminating newline. generation guards against a race with
+// bufferPartial: if new data arrived (and rescheduled the timer) between
+// this timer firing and the flush acquiring partialsMu, the pending
+// message's generation will have moved on and the flush is skipped so the
+// newer timer can run out its own full partialMessageTimeout.
+func (ad *Adapter) flushPartial(key string, generation int) {
+	ad.partialsMu.Lock()
+	pending, buffering := ad.partials[key]
+	if buffering && pending.generation == generation {
+		delete(ad.partials, key)
+	} else {
+		buffering = false
 	}
-	_, err := transport.Dial(route.Address, route.Options)
+	ad.partialsMu.Unlock()
+
+	if !buffering {
+		return
+	}
+
+	merged := *pending.message
+	merged.Data = pending.buf.String()
+	ad.send(&merged)
+}
+
+// handle processes a single message: skipping empties, buffering partial
+// lines when configured, and sending whatever remains.
+func (ad *Adapter) handle(message *router.Message) {
+	if emptyMessagePattern.MatchString(message.Data) {
+		emptyMessagesSkipped.Inc()
+		log.Println("Skipping empty message!")
+		return
+	}
+
+	if ad.partialMessageBuffer {
+		message = ad.bufferPartial(message)
+		if message == nil {
+			return
+		}
+	}
+
+	ad.send(message)
+}
+
+// Stream handles a stream of messages from Logspout. Implements router.logAdapter.
+// On SIGTERM/SIGINT it stops reading new messages, drains whatever is
+// already queued in logstream (up to shutdownTimeout), closes the fluentd
+// connection and exits the process.
+//
+// signal.Notify suppresses Go's default terminate-on-SIGTERM/SIGINT
+// behavior process-wide, not just for this adapter, and logspout itself
+// has no signal handling of its own. So once we register for these
+// signals we own seeing the process out: draining and returning here
+// without calling os.Exit would leave the rest of the daemon running
+// indefinitely, relying on an orchestrator's SIGKILL to actually stop it.
+func (ad *Adapter) Stream(logstream chan *router.Message) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case sig := <-sigCh:
+			log.Println("fluentd-adapter received signal, draining: ", sig)
+			ad.drain(logstream)
+			os.Exit(0)
+		case message, ok := <-logstream:
+			if !ok {
+				ad.closeWriter()
+				return
+			}
+			ad.handle(message)
+		}
+	}
+}
+
+// drain forwards whatever is left on logstream, giving up after
+// shutdownTimeout, then closes the fluentd connection.
+func (ad *Adapter) drain(logstream chan *router.Message) {
+	defer ad.closeWriter()
+
+	timeout := time.After(ad.shutdownTimeout)
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			ad.handle(message)
+		case <-timeout:
+			log.Println("fluentd-adapter shutdown timeout exceeded, dropping remaining messages")
+			return
+		}
+	}
+}
+
+func (ad *Adapter) closeWriter() {
+	ad.pool.close()
+}
+
+// connectEndpoint dials address (after verifying connectivity through
+// logspout's own transport) and builds the fluentd writer for it.
+func connectEndpoint(route *router.Route, address string) (*endpoint, error) {
+	loc, err := parseAddress(address)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("Connectivity successful to fluentd @ " + route.Address)
 
-	// Construct fluentd config object
-	host, port, err := net.SplitHostPort(route.Address)
-	portNum, err := strconv.Atoi(port)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Invalid fluentd-address %s", route.Address)
+	// Connectivity checks only make sense for network transports; a unix
+	// socket is dialed directly by the fluent client below.
+	if loc.protocol != "unix" {
+		transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+		if !found {
+			return nil, errors.New("Unable to find adapter: " + route.Adapter)
+		}
+		if _, err := transport.Dial(net.JoinHostPort(loc.host, strconv.Itoa(loc.port)), route.Options); err != nil {
+			return nil, err
+		}
+	}
+	log.Println("Connectivity successful to fluentd @ " + address)
+
+	var insecureSkipVerify bool
+	fluentNetwork := loc.protocol
+	if loc.protocol == "tls" {
+		insecureSkipVerify, err = tlsInsecureSkipVerify()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid fluentd TLS configuration")
+		}
+		fluentNetwork = "tcp"
 	}
 
 	bufferLimit, err := strconv.Atoi(getenv("FLUENTD_BUFFER_LIMIT", strconv.Itoa(defaultBufferLimit)))
@@ -138,29 +825,122 @@ func NewAdapter(route *router.Route) (router.LogAdapter, error) {
 	}
 
 	fluentConfig := fluent.Config{
-		FluentHost:         host,
-		FluentPort:         portNum,
-		FluentNetwork:      defaultProtocol,
-		FluentSocketPath:   "",
-		BufferLimit:        bufferLimit,
-		RetryWait:          retryWait,
-		MaxRetry:           maxRetries,
-		Async:              asyncConnect,
-		SubSecondPrecision: subSecondPrecision,
-		RequestAck:         true,
+		FluentHost:            loc.host,
+		FluentPort:            loc.port,
+		FluentNetwork:         fluentNetwork,
+		FluentSocketPath:      loc.path,
+		TlsInsecureSkipVerify: insecureSkipVerify,
+		BufferLimit:           bufferLimit,
+		RetryWait:             retryWait,
+		MaxRetry:              maxRetries,
+		Async:                 asyncConnect,
+		SubSecondPrecision:    subSecondPrecision,
+		RequestAck:            true,
 	}
 	writer, err := fluent.New(fluentConfig)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Unable to create fluentd logger")
+		return nil, errors.Wrapf(err, "Unable to create fluentd logger for %s", address)
+	}
+
+	return newEndpoint(address, writer), nil
+}
+
+// NewAdapter creates a Logspout fluentd adapter instance.
+func NewAdapter(route *router.Route) (router.LogAdapter, error) {
+	addresses := splitCSV(route.Address)
+	if len(addresses) == 0 {
+		return nil, errors.New("fluentd-address is empty")
+	}
+
+	endpoints := make([]*endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		ep, err := connectEndpoint(route, address)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	lbStrategy := getenv("FLUENTD_LB_STRATEGY", lbStrategyFailover)
+	if lbStrategy != lbStrategyFailover && lbStrategy != lbStrategyRoundRobin {
+		return nil, errors.Errorf("invalid FLUENTD_LB_STRATEGY %s", lbStrategy)
+	}
+
+	roundRobinEvery, err := strconv.Atoi(getenv("FLUENTD_LB_ROUNDROBIN_EVERY", strconv.Itoa(defaultLBRoundRobinEvery)))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := newWriterPool(endpoints, lbStrategy, roundRobinEvery)
+
+	var tagTemplate *template.Template
+	if tagFormat := getenv("FLUENTD_TAG", ""); tagFormat != "" {
+		tagTemplate, err = template.New("fluentd-tag").Parse(tagFormat)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid FLUENTD_TAG template %s", tagFormat)
+		}
+	}
+
+	daemonName, err := os.Hostname()
+	if err != nil {
+		daemonName = ""
+	}
+
+	extraFields, err := parseExtraFields(getenv("FLUENTD_EXTRA", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	parseJSON, err := strconv.ParseBool(getenv("FLUENTD_PARSE_JSON", "false"))
+	if err != nil {
+		return nil, err
+	}
+
+	partialMessageBuffer, err := strconv.ParseBool(getenv("FLUENTD_PARTIAL_MESSAGE_BUFFER", "false"))
+	if err != nil {
+		return nil, err
+	}
+
+	partialMessageTimeout := defaultPartialMessageTimeout
+	if timeoutMs := getenv("FLUENTD_PARTIAL_MESSAGE_TIMEOUT", ""); timeoutMs != "" {
+		ms, err := strconv.Atoi(timeoutMs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid FLUENTD_PARTIAL_MESSAGE_TIMEOUT %s", timeoutMs)
+		}
+		partialMessageTimeout = time.Duration(ms) * time.Millisecond
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if timeoutMs := getenv("FLUENTD_SHUTDOWN_TIMEOUT", ""); timeoutMs != "" {
+		ms, err := strconv.Atoi(timeoutMs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid FLUENTD_SHUTDOWN_TIMEOUT %s", timeoutMs)
+		}
+		shutdownTimeout = time.Duration(ms) * time.Millisecond
+	}
+
+	if metricsAddr := getenv("FLUENTD_METRICS_ADDR", ""); metricsAddr != "" {
+		serveMetrics(metricsAddr)
 	}
 
 	return &Adapter{
-		writer:         writer,
-		tagPrefix:      getenv("TAG_PREFIX", "docker"),
-		tagSuffixLabel: getenv("TAG_SUFFIX_LABEL", ""),
+		pool:                  pool,
+		tagPrefix:             getenv("TAG_PREFIX", "docker"),
+		tagSuffixLabel:        getenv("TAG_SUFFIX_LABEL", ""),
+		tagTemplate:           tagTemplate,
+		daemonName:            daemonName,
+		extraFields:           extraFields,
+		labelKeys:             splitCSV(getenv("FLUENTD_LABELS", "")),
+		envKeys:               splitCSV(getenv("FLUENTD_ENV", "")),
+		parseJSON:             parseJSON,
+		messageKey:            getenv("FLUENTD_MESSAGE_KEY", defaultMessageKey),
+		partialMessageBuffer:  partialMessageBuffer,
+		partialMessageTimeout: partialMessageTimeout,
+		partials:              make(map[string]*partialMessage),
+		shutdownTimeout:       shutdownTimeout,
 	}, nil
 }
 
 func init() {
 	router.AdapterFactories.Register(NewAdapter, "fluentd")
-}
\ No newline at end of file
+}
@@ -0,0 +1,109 @@
+package fluentd
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func newTestMessage(data string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			ID:   "abc123",
+			Name: "/web",
+			Config: &docker.Config{
+				Hostname: "abc123",
+			},
+		},
+		Source: "stdout",
+		Data:   data,
+	}
+}
+
+func TestBuildRecordParseJSON(t *testing.T) {
+	ad := &Adapter{parseJSON: true, messageKey: "log"}
+
+	cases := []struct {
+		name string
+		data string
+	}{
+		{"object", `{"foo":"bar"}`},
+		{"null", "null"},
+		{"array", "[1,2,3]"},
+		{"scalar", "42"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("buildRecord panicked on %q: %v", c.data, r)
+				}
+			}()
+			ad.buildRecord(newTestMessage(c.data))
+		})
+	}
+}
+
+func TestBuildRecordParseJSONObject(t *testing.T) {
+	ad := &Adapter{parseJSON: true, messageKey: "log"}
+
+	record := ad.buildRecord(newTestMessage(`{"foo":"bar"}`))
+	parsed, ok := record.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a parsed JSON record, got %T", record)
+	}
+	if parsed["foo"] != "bar" {
+		t.Errorf("expected foo=bar, got %v", parsed["foo"])
+	}
+	if parsed["container_id"] != "abc123" {
+		t.Errorf("expected container_id to be injected, got %v", parsed["container_id"])
+	}
+}
+
+func TestNewTagContextImageIDVsImageName(t *testing.T) {
+	message := &router.Message{
+		Container: &docker.Container{
+			ID:    "abc123",
+			Name:  "/web",
+			Image: "sha256:deadbeef",
+			Config: &docker.Config{
+				Image: "nginx:latest",
+			},
+		},
+		Source: "stdout",
+	}
+
+	ctx := newTagContext(message, "daemon1")
+	if ctx.ImageID != "sha256:deadbeef" {
+		t.Errorf("expected ImageID to be the resolved image ID, got %v", ctx.ImageID)
+	}
+	if ctx.ImageName != "nginx:latest" {
+		t.Errorf("expected ImageName to be the image reference, got %v", ctx.ImageName)
+	}
+}
+
+func TestTlsInsecureSkipVerifyRejectsUnsupportedCAEnvVars(t *testing.T) {
+	for _, name := range []string{"FLUENTD_TLS_CA", "FLUENTD_TLS_CERT", "FLUENTD_TLS_KEY"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, "/tmp/whatever")
+			if _, err := tlsInsecureSkipVerify(); err == nil {
+				t.Fatalf("expected an error when %s is set, got nil", name)
+			}
+		})
+	}
+}
+
+func TestBuildRecordParseJSONNullFallsBackToMessageKey(t *testing.T) {
+	ad := &Adapter{parseJSON: true, messageKey: "log"}
+
+	record := ad.buildRecord(newTestMessage("null"))
+	plain, ok := record.(map[string]string)
+	if !ok {
+		t.Fatalf("expected a plain string record for non-object JSON, got %T", record)
+	}
+	if plain["log"] != "null" {
+		t.Errorf("expected log=null, got %v", plain["log"])
+	}
+}